@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+func init() {
+	registerProvider("aws", newAWSProvider)
+}
+
+// awsProvider walks an AWS Organizations OU for member accounts, lists
+// each account's EKS clusters, and builds kubeconfigs that shell out to
+// `aws eks get-token` for authentication, mirroring what `aws eks
+// update-kubeconfig` itself configures.
+type awsProvider struct {
+	sess *session.Session
+}
+
+// crossAccountRole is the IAM role org-kubectl assumes in every member
+// account it discovers, matching the role AWS Organizations sets up for
+// the management account by default. Accounts that use a different role
+// name need a follow-up flag; for now this mirrors what most orgs have.
+const crossAccountRole = "OrganizationAccountAccessRole"
+
+func newAWSProvider() (Provider, error) {
+	sess, err := session.NewSessionWithOptions(session.Options{SharedConfigState: session.SharedConfigEnable})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create aws session")
+	}
+	return &awsProvider{sess: sess}, nil
+}
+
+func (p *awsProvider) ListClusters(ctx context.Context, scope string) ([]Cluster, error) {
+	cachePath := path.Join(os.Getenv("HOME"), ".kube", "cache", "org-kubectl", "aws.json")
+	cache, _ := openCache(cachePath)
+	defer func() {
+		if err := saveCache(cachePath, cache); err != nil {
+			glog.Errorf("could not save cache %v: %v", cachePath, err)
+		}
+	}()
+
+	accountIDs, err := childAccounts(ctx, organizations.New(p.sess), scope, cache)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not list accounts under %v", scope)
+	}
+
+	var clusters []Cluster
+	for _, accountID := range accountIDs {
+		eksSvc := eks.New(p.sess, p.sess.Config.Copy().WithCredentials(assumeRoleCreds(p.sess, accountID)))
+
+		var names []string
+		err := eksSvc.ListClustersPagesWithContext(ctx, &eks.ListClustersInput{},
+			func(out *eks.ListClustersOutput, last bool) bool {
+				for _, n := range out.Clusters {
+					names = append(names, aws.StringValue(n))
+				}
+				return true
+			})
+		if err != nil {
+			glog.Errorf("could not list eks clusters in account %v: %v", accountID, err)
+			continue
+		}
+
+		for _, name := range names {
+			desc, err := eksSvc.DescribeClusterWithContext(ctx, &eks.DescribeClusterInput{Name: aws.String(name)})
+			if err != nil {
+				glog.Errorf("could not describe eks cluster %v in %v: %v", name, accountID, err)
+				continue
+			}
+			clusters = append(clusters, Cluster{
+				Project:      accountID,
+				Location:     regionFromARN(aws.StringValue(desc.Cluster.Arn)),
+				Name:         name,
+				providerData: desc.Cluster,
+			})
+		}
+	}
+	return clusters, nil
+}
+
+// regionFromARN pulls the region segment out of a cluster ARN
+// (arn:aws:eks:region:account-id:cluster/name), since the ARN itself
+// contains ":" and "/" that would corrupt a bundle archive path if used
+// as-is for Cluster.Location.
+func regionFromARN(arn string) string {
+	parts := strings.SplitN(arn, ":", 5)
+	if len(parts) < 5 {
+		return arn
+	}
+	return parts[3]
+}
+
+// assumeRoleCreds returns credentials for crossAccountRole in accountID,
+// assumed via sess's own credentials. EKS calls made with them are scoped
+// to that one account, which is what lets ListClusters walk every account
+// under an OU instead of repeatedly querying the management account.
+func assumeRoleCreds(sess *session.Session, accountID string) *credentials.Credentials {
+	roleARN := fmt.Sprintf("arn:aws:iam::%v:role/%v", accountID, crossAccountRole)
+	return stscreds.NewCredentials(sess, roleARN)
+}
+
+func (p *awsProvider) Kubeconfig(ctx context.Context, c Cluster) (*api.Config, error) {
+	cluster := c.providerData.(*eks.Cluster)
+
+	caData, err := base64.StdEncoding.DecodeString(aws.StringValue(cluster.CertificateAuthority.Data))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not decode cluster CA certificate")
+	}
+
+	contextName := fmt.Sprintf("eks_%v_%v", c.Project, c.Name)
+
+	cfg := api.NewConfig()
+	cfg.Clusters[contextName] = &api.Cluster{
+		Server:                   aws.StringValue(cluster.Endpoint),
+		CertificateAuthorityData: caData,
+	}
+	roleARN := fmt.Sprintf("arn:aws:iam::%v:role/%v", c.Project, crossAccountRole)
+	cfg.AuthInfos[contextName] = &api.AuthInfo{
+		Exec: &api.ExecConfig{
+			APIVersion: "client.authentication.k8s.io/v1beta1",
+			Command:    "aws",
+			Args:       []string{"eks", "get-token", "--cluster-name", c.Name, "--role-arn", roleARN},
+		},
+	}
+	cfg.Contexts[contextName] = &api.Context{
+		Cluster:  contextName,
+		AuthInfo: contextName,
+	}
+	cfg.CurrentContext = contextName
+	return cfg, nil
+}
+
+// childAccounts lists every account ID under an organizational unit,
+// recursing into nested OUs, and caches the result the same way the gcp
+// provider caches ancestry lookups.
+func childAccounts(ctx context.Context, org *organizations.Organizations, ouID string, cache map[string][]string) ([]string, error) {
+	if ids, ok := cache[ouID]; ok {
+		return ids, nil
+	}
+
+	var accountIDs []string
+	err := org.ListAccountsForParentPagesWithContext(ctx, &organizations.ListAccountsForParentInput{ParentId: aws.String(ouID)},
+		func(out *organizations.ListAccountsForParentOutput, last bool) bool {
+			for _, a := range out.Accounts {
+				accountIDs = append(accountIDs, aws.StringValue(a.Id))
+			}
+			return true
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	var childOUs []string
+	err = org.ListOrganizationalUnitsForParentPagesWithContext(ctx, &organizations.ListOrganizationalUnitsForParentInput{ParentId: aws.String(ouID)},
+		func(out *organizations.ListOrganizationalUnitsForParentOutput, last bool) bool {
+			for _, ou := range out.OrganizationalUnits {
+				childOUs = append(childOUs, aws.StringValue(ou.Id))
+			}
+			return true
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, child := range childOUs {
+		nested, err := childAccounts(ctx, org, child, cache)
+		if err != nil {
+			return nil, err
+		}
+		accountIDs = append(accountIDs, nested...)
+	}
+
+	cache[ouID] = accountIDs
+	return accountIDs, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestRegionFromARN(t *testing.T) {
+	cases := []struct {
+		name string
+		arn  string
+		want string
+	}{
+		{
+			name: "cluster arn",
+			arn:  "arn:aws:eks:us-east-1:123456789012:cluster/mycluster",
+			want: "us-east-1",
+		},
+		{
+			name: "different partition and region",
+			arn:  "arn:aws-us-gov:eks:us-gov-west-1:123456789012:cluster/mycluster",
+			want: "us-gov-west-1",
+		},
+		{
+			name: "not an arn falls back to the input unchanged",
+			arn:  "not-an-arn",
+			want: "not-an-arn",
+		},
+		{
+			name: "empty string",
+			arn:  "",
+			want: "",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := regionFromARN(tc.arn); got != tc.want {
+				t.Errorf("regionFromARN(%q) = %q, want %q", tc.arn, got, tc.want)
+			}
+		})
+	}
+}
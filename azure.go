@@ -0,0 +1,29 @@
+package main
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+func init() {
+	registerProvider("azure", newAzureProvider)
+}
+
+// azureProvider is a stub for discovering AKS clusters under an Azure
+// Management Group. Wiring up the Azure SDK is left for a follow-up; it
+// fails clearly rather than silently reporting zero clusters.
+type azureProvider struct{}
+
+func newAzureProvider() (Provider, error) {
+	return &azureProvider{}, nil
+}
+
+func (p *azureProvider) ListClusters(ctx context.Context, scope string) ([]Cluster, error) {
+	return nil, errors.Errorf("azure provider is not implemented yet (scope %q); AKS discovery via Management Groups is tracked as follow-up work", scope)
+}
+
+func (p *azureProvider) Kubeconfig(ctx context.Context, c Cluster) (*api.Config, error) {
+	return nil, errors.New("azure provider is not implemented yet")
+}
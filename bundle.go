@@ -0,0 +1,191 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/gosuri/uiprogress"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// collector is a single read-only kubectl invocation to run against every
+// discovered cluster and capture into the bundle.
+type collector struct {
+	Name string
+	Args []string
+}
+
+// defaultCollectors mirrors the read-only diagnostics a human would run by
+// hand when triaging a cluster.
+var defaultCollectors = []collector{
+	{Name: "nodes", Args: []string{"get", "nodes", "-o", "yaml"}},
+	{Name: "pods", Args: []string{"get", "pods", "-A", "-o", "wide"}},
+	{Name: "events", Args: []string{"get", "events", "-A"}},
+	{Name: "describe-nodes", Args: []string{"describe", "nodes"}},
+	{Name: "version", Args: []string{"version"}},
+}
+
+// loadCollectors returns the default collector set, plus any extra
+// name -> argv collectors defined in a YAML file at path. An empty path
+// just returns the defaults.
+func loadCollectors(path string) ([]collector, error) {
+	collectors := append([]collector{}, defaultCollectors...)
+	if path == "" {
+		return collectors, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read collectors file")
+	}
+
+	var extra map[string][]string
+	if err := yaml.Unmarshal(data, &extra); err != nil {
+		return nil, errors.Wrap(err, "could not parse collectors file")
+	}
+	for name, args := range extra {
+		collectors = append(collectors, collector{Name: name, Args: args})
+	}
+	return collectors, nil
+}
+
+// runBundle fans out the collector set across every cluster and writes the
+// results into a zip archive at outputPath, laid out as
+// <project>_<location>_<cluster>/<collector>.txt. A cluster or collector
+// failing is recorded in errors.txt inside the archive rather than
+// aborting the run; it returns the process exit code, 1 if anything
+// failed.
+func runBundle(ctx context.Context, clusters []Cluster, outputPath string, collectors []collector) int {
+	archive, err := os.Create(outputPath)
+	if err != nil {
+		glog.Errorf("could not create archive %v: %v", outputPath, err)
+		return 1
+	}
+	defer archive.Close()
+
+	zw := zip.NewWriter(archive)
+	defer zw.Close()
+	var zipMu sync.Mutex
+
+	var errMu sync.Mutex
+	var bundleErrors []string
+	recordError := func(format string, a ...interface{}) {
+		errMu.Lock()
+		bundleErrors = append(bundleErrors, fmt.Sprintf(format, a...))
+		errMu.Unlock()
+	}
+
+	uiprogress.Start()
+	defer uiprogress.Stop()
+	bar := uiprogress.AddBar(len(clusters) * (len(collectors) + 1))
+	bar.AppendCompleted()
+	bar.PrependElapsed()
+
+	sem := make(chan struct{}, *parallelism)
+	var wg sync.WaitGroup
+	for _, c := range clusters {
+		c := c
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			label := c.Label()
+			dir := fmt.Sprintf("%v_%v_%v", c.Project, c.Location, c.Name)
+
+			writeZipEntry(&zipMu, zw, path.Join(dir, "metadata.txt"), clusterMetadata(c))
+			bar.Incr()
+
+			cfg, err := c.provider.Kubeconfig(ctx, c)
+			if err != nil {
+				recordError("%v: could not build kubeconfig: %v", label, err)
+				for range collectors {
+					bar.Incr()
+				}
+				return
+			}
+			contextName, kubeconfigPath, err := writeKubeconfigFile(cfg)
+			if err != nil {
+				recordError("%v: could not write kubeconfig: %v", label, err)
+				for range collectors {
+					bar.Incr()
+				}
+				return
+			}
+			defer os.Remove(kubeconfigPath)
+
+			collectorSem := make(chan struct{}, *collectorParallelism)
+			var cwg sync.WaitGroup
+			for _, c := range collectors {
+				c := c
+				cwg.Add(1)
+				go func() {
+					defer cwg.Done()
+					collectorSem <- struct{}{}
+					defer func() { <-collectorSem }()
+					defer bar.Incr()
+
+					out, err := captureKubectl(ctx, contextName, kubeconfigPath, c.Args)
+					if err != nil {
+						recordError("%v/%v: %v", label, c.Name, err)
+					}
+					writeZipEntry(&zipMu, zw, path.Join(dir, c.Name+".txt"), out)
+				}()
+			}
+			cwg.Wait()
+		}()
+	}
+	wg.Wait()
+
+	writeZipEntry(&zipMu, zw, "errors.txt", []byte(strings.Join(bundleErrors, "\n")))
+
+	if len(bundleErrors) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// clusterMetadata renders the cluster identity known from discovery, ahead
+// of running any collector, so the archive is useful even if every
+// collector fails.
+func clusterMetadata(c Cluster) []byte {
+	return []byte(fmt.Sprintf("project: %v\nlocation: %v\nname: %v\n", c.Project, c.Location, c.Name))
+}
+
+// captureKubectl runs kubectl against a single cluster and returns its
+// combined stdout/stderr, for collectors where we want the whole output in
+// one archive entry rather than streamed line-by-line.
+func captureKubectl(ctx context.Context, contextName string, kubeconfigPath string, args []string) ([]byte, error) {
+	fullArgs := append([]string{"--context", contextName}, args...)
+	cmd := exec.CommandContext(ctx, "kubectl", fullArgs...)
+	cmd.Env = append(os.Environ(), "KUBECONFIG="+kubeconfigPath)
+
+	out, err := cmd.CombinedOutput()
+	return out, errors.Wrap(err, "could not run kubectl")
+}
+
+// writeZipEntry serializes concurrent writes into the shared zip.Writer,
+// which isn't safe to use from more than one goroutine at a time.
+func writeZipEntry(mu *sync.Mutex, zw *zip.Writer, name string, data []byte) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	w, err := zw.Create(name)
+	if err != nil {
+		glog.Errorf("could not create zip entry %v: %v", name, err)
+		return
+	}
+	if _, err := w.Write(data); err != nil {
+		glog.Errorf("could not write zip entry %v: %v", name, err)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/api/cloudresourcemanager/v1beta1"
+	"google.golang.org/api/container/v1"
+	gkehub "google.golang.org/api/gkehub/v1beta1"
+)
+
+// ClusterRef identifies a single cluster to run kubectl against, along with
+// enough information to build a kubeconfig for it. It's the unit Discoverer
+// implementations produce, so the folder and fleet discovery backends can
+// feed the same fan-out loop in main.
+type ClusterRef struct {
+	Project  string
+	Location string
+	Name     string
+
+	// gkeCluster is set for clusters discovered directly through the
+	// container API, which already gives us the endpoint and CA needed to
+	// talk to the cluster without any further lookups.
+	gkeCluster *container.Cluster
+
+	// membership is set for clusters discovered through a Fleet, which
+	// must be reached through the Connect Gateway rather than directly.
+	membership string
+}
+
+// Discoverer finds the clusters org-kubectl should run against.
+type Discoverer interface {
+	Discover(ctx context.Context) ([]ClusterRef, error)
+}
+
+// folderDiscoverer lists every GKE cluster in projects descending from a
+// cloud resource manager folder, the original discovery mode.
+type folderDiscoverer struct {
+	crm    *cloudresourcemanager.Service
+	gke    *container.Service
+	folder string
+	cache  map[string][]string
+}
+
+func (d *folderDiscoverer) Discover(ctx context.Context) ([]ClusterRef, error) {
+	projects, err := findChildProjects(ctx, d.crm, d.folder, d.cache)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not find projects")
+	}
+
+	var refs []ClusterRef
+	for _, p := range projects {
+		resp, err := d.gke.Projects.Zones.Clusters.List(p, "-").Context(ctx).Do()
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not list gke clusters in %v", p)
+		}
+		for _, c := range resp.Clusters {
+			location := c.Zone
+			if location == "" {
+				location = c.Location
+			}
+			refs = append(refs, ClusterRef{
+				Project:    p,
+				Location:   location,
+				Name:       c.Name,
+				gkeCluster: c,
+			})
+		}
+	}
+	return refs, nil
+}
+
+// fleetDiscoverer lists every membership registered to a GKE Hub fleet,
+// which covers attached (non-GKE) clusters as well as GKE ones, and reaches
+// all of them through the Connect Gateway rather than a direct connection.
+type fleetDiscoverer struct {
+	hub        *gkehub.Service
+	hubProject string
+}
+
+func (d *fleetDiscoverer) Discover(ctx context.Context) ([]ClusterRef, error) {
+	var refs []ClusterRef
+
+	parent := "projects/" + d.hubProject + "/locations/-"
+	err := d.hub.Projects.Locations.Memberships.List(parent).Pages(ctx,
+		func(r *gkehub.ListMembershipsResponse) error {
+			for _, m := range r.Resources {
+				parts := strings.Split(m.Name, "/")
+				if len(parts) != 6 {
+					glog.Warningf("unexpected membership name %v, skipping", m.Name)
+					continue
+				}
+				refs = append(refs, ClusterRef{
+					Project:    d.hubProject,
+					Location:   parts[3],
+					Name:       parts[5],
+					membership: m.Name,
+				})
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list fleet memberships")
+	}
+	return refs, nil
+}
+
+func findChildProjects(ctx context.Context, crm *cloudresourcemanager.Service, parentResourceID string, ancestorCache map[string][]string) ([]string, error) {
+	projects, err := listProjects(ctx, crm)
+	if err != nil {
+		return nil, err
+	}
+
+	filteredProjects := []string{}
+	mu := &sync.Mutex{}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	grp, ctx := errgroup.WithContext(ctx)
+
+	glog.Infof("looking for projects with ancestors %v", parentResourceID)
+	for _, p := range projects {
+		p := p
+
+		mu.Lock()
+		ancestors, ok := ancestorCache[p]
+		mu.Unlock()
+		if !ok {
+			grp.Go(func() error {
+				resp, err := crm.Projects.GetAncestry(p, &cloudresourcemanager.GetAncestryRequest{}).Context(ctx).Do()
+				if err != nil {
+					return errors.Wrapf(err, "could not get ancestry for %v", p)
+				}
+				ancestors := []string{}
+				for _, ancestor := range resp.Ancestor {
+					ancestors = append(ancestors, ancestor.ResourceId.Id)
+					glog.Infof("ancestry for %v: %v", p, ancestor.ResourceId.Id)
+					if ancestor.ResourceId.Id == parentResourceID {
+						mu.Lock()
+						filteredProjects = append(filteredProjects, p)
+						mu.Unlock()
+					}
+				}
+				mu.Lock()
+				ancestorCache[p] = ancestors
+				mu.Unlock()
+				return nil
+			})
+		} else {
+			for _, ancestor := range ancestors {
+				if ancestor == parentResourceID {
+					mu.Lock()
+					filteredProjects = append(filteredProjects, p)
+					mu.Unlock()
+				}
+			}
+		}
+	}
+
+	if err := grp.Wait(); err != nil {
+		return nil, errors.Wrap(err, "could not get project ancestors")
+	}
+	return filteredProjects, nil
+}
+
+func listProjects(ctx context.Context, crm *cloudresourcemanager.Service) ([]string, error) {
+	projects := []string{}
+	err := crm.Projects.List().Context(ctx).Pages(
+		ctx, func(r *cloudresourcemanager.ListProjectsResponse) error {
+			for _, p := range r.Projects {
+				projects = append(projects, p.ProjectId)
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list projects")
+	}
+	return projects, nil
+}
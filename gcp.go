@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/cloudresourcemanager/v1beta1"
+	"google.golang.org/api/container/v1"
+	gkehub "google.golang.org/api/gkehub/v1beta1"
+	"google.golang.org/api/option"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+func init() {
+	registerProvider("gcp", newGCPProvider)
+}
+
+// gcpProvider is the Provider for gcp:// scopes, covering both GKE-in-a-folder
+// discovery (the original org-kubectl behaviour) and GKE Hub fleet
+// discovery, selected by the scope's leading path segment:
+// gcp://folders/<id> or gcp://fleet/<hub-project>.
+type gcpProvider struct {
+	httpClient *http.Client
+	crm        *cloudresourcemanager.Service
+	gke        *container.Service
+	hub        *gkehub.Service // created lazily, only needed for fleet scopes
+}
+
+func newGCPProvider() (Provider, error) {
+	ctx := context.Background()
+
+	httpClient, err := google.DefaultClient(ctx, cloudresourcemanager.CloudPlatformReadOnlyScope)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not authenticate to google")
+	}
+	crm, err := cloudresourcemanager.New(httpClient)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create cloudresourcemanger client")
+	}
+	gke, err := container.New(httpClient)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create gke client")
+	}
+	return &gcpProvider{httpClient: httpClient, crm: crm, gke: gke}, nil
+}
+
+func (p *gcpProvider) ListClusters(ctx context.Context, scope string) ([]Cluster, error) {
+	parts := strings.SplitN(scope, "/", 2)
+	if len(parts) != 2 {
+		return nil, errors.Errorf("gcp scope must be folders/<id> or fleet/<project>, got %q", scope)
+	}
+	kind, id := parts[0], parts[1]
+
+	cachePath := path.Join(os.Getenv("HOME"), ".kube", "cache", "org-kubectl", "gcp.json")
+	cache, _ := openCache(cachePath)
+	defer func() {
+		if err := saveCache(cachePath, cache); err != nil {
+			glog.Errorf("could not save cache %v: %v", cachePath, err)
+		}
+	}()
+
+	var d Discoverer
+	switch kind {
+	case "folders":
+		d = &folderDiscoverer{crm: p.crm, gke: p.gke, folder: id, cache: cache}
+	case "fleet":
+		hub, err := p.hubClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		d = &fleetDiscoverer{hub: hub, hubProject: id}
+	default:
+		return nil, errors.Errorf("unknown gcp scope kind %q, want folders or fleet", kind)
+	}
+
+	refs, err := d.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return toClusters(refs), nil
+}
+
+func (p *gcpProvider) hubClient(ctx context.Context) (*gkehub.Service, error) {
+	if p.hub != nil {
+		return p.hub, nil
+	}
+	hub, err := gkehub.NewService(ctx, option.WithHTTPClient(p.httpClient))
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create gkehub client")
+	}
+	p.hub = hub
+	return hub, nil
+}
+
+func toClusters(refs []ClusterRef) []Cluster {
+	clusters := make([]Cluster, len(refs))
+	for i, r := range refs {
+		clusters[i] = Cluster{Project: r.Project, Location: r.Location, Name: r.Name, providerData: r}
+	}
+	return clusters
+}
+
+func (p *gcpProvider) Kubeconfig(ctx context.Context, c Cluster) (*api.Config, error) {
+	ref := c.providerData.(ClusterRef)
+	if ref.membership != "" {
+		_, cfg, err := gatewayKubeconfig(ctx, p.httpClient, ref)
+		return cfg, err
+	}
+	_, cfg, err := gkeKubeconfig(ctx, ref)
+	return cfg, err
+}
+
+// gkeKubeconfig builds a kubeconfig that talks to a GKE cluster's master
+// directly, using the endpoint and CA certificate already returned by the
+// container API.
+func gkeKubeconfig(ctx context.Context, ref ClusterRef) (string, *api.Config, error) {
+	c := ref.gkeCluster
+	contextName := fmt.Sprintf("gke_%v_%v_%v", ref.Project, ref.Location, ref.Name)
+
+	caData, err := base64.StdEncoding.DecodeString(c.MasterAuth.ClusterCaCertificate)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "could not decode cluster CA certificate")
+	}
+
+	user, err := gkeAuthInfo(ctx)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "could not build auth info")
+	}
+
+	cfg := api.NewConfig()
+	cfg.Clusters[contextName] = &api.Cluster{
+		Server:                   "https://" + c.Endpoint,
+		CertificateAuthorityData: caData,
+	}
+	cfg.AuthInfos[contextName] = user
+	cfg.Contexts[contextName] = &api.Context{
+		Cluster:  contextName,
+		AuthInfo: contextName,
+	}
+	cfg.CurrentContext = contextName
+	return contextName, cfg, nil
+}
+
+// gkeAuthInfo prefers an exec-plugin user invoking gke-gcloud-auth-plugin,
+// matching what gcloud itself now configures, and falls back to a bearer
+// token from the default application credentials when the plugin isn't on
+// PATH.
+func gkeAuthInfo(ctx context.Context) (*api.AuthInfo, error) {
+	if _, err := exec.LookPath("gke-gcloud-auth-plugin"); err == nil {
+		return &api.AuthInfo{
+			Exec: &api.ExecConfig{
+				APIVersion:         "client.authentication.k8s.io/v1beta1",
+				Command:            "gke-gcloud-auth-plugin",
+				InstallHint:        "Install gke-gcloud-auth-plugin: https://cloud.google.com/blog/products/containers-kubernetes/kubectl-auth-changes-in-gke",
+				ProvideClusterInfo: true,
+			},
+		}, nil
+	}
+
+	ts, err := google.DefaultTokenSource(ctx, container.CloudPlatformScope)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get default token source")
+	}
+	token, err := ts.Token()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not get access token")
+	}
+	return &api.AuthInfo{Token: token.AccessToken}, nil
+}
+
+// connectGatewayEndpoint is the Connect Gateway host that proxies kubectl
+// traffic to a fleet-registered cluster without requiring any network path
+// to the cluster itself.
+const connectGatewayEndpoint = "https://connectgateway.googleapis.com/v1"
+
+// gatewayKubeconfig asks the Connect Gateway to generate a ready-to-use
+// kubeconfig for a fleet membership, so org-kubectl never needs direct
+// network access to the member cluster (GKE, EKS, or on-prem).
+func gatewayKubeconfig(ctx context.Context, httpClient *http.Client, ref ClusterRef) (string, *api.Config, error) {
+	url := fmt.Sprintf("%v/%v:generateCredentials", connectGatewayEndpoint, ref.membership)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "could not build connect gateway request")
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "could not call connect gateway")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return "", nil, errors.Errorf("connect gateway returned %v: %s", resp.Status, body)
+	}
+
+	var cr generateCredentialsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&cr); err != nil {
+		return "", nil, errors.Wrap(err, "could not decode connect gateway response")
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(cr.Kubeconfig)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "could not decode generated kubeconfig")
+	}
+
+	cfg, err := clientcmd.Load(raw)
+	if err != nil {
+		return "", nil, errors.Wrap(err, "could not parse generated kubeconfig")
+	}
+	return cfg.CurrentContext, cfg, nil
+}
+
+// generateCredentialsResponse mirrors the JSON body returned by the Connect
+// Gateway's generateCredentials endpoint.
+type generateCredentialsResponse struct {
+	Kubeconfig string `json:"kubeconfig"`
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+// writeKubeconfigFile writes cfg to a private temp file, so concurrent
+// invocations never share or clobber ~/.kube/config. It returns the
+// context name to pass to kubectl's --context flag and the path of the
+// kubeconfig file, which the caller is responsible for removing.
+func writeKubeconfigFile(cfg *api.Config) (string, string, error) {
+	f, err := ioutil.TempFile("", "org-kubectl-kubeconfig-")
+	if err != nil {
+		return "", "", errors.Wrap(err, "could not create kubeconfig file")
+	}
+	f.Close()
+
+	if err := clientcmd.WriteToFile(*cfg, f.Name()); err != nil {
+		os.Remove(f.Name())
+		return "", "", errors.Wrap(err, "could not write kubeconfig")
+	}
+	return cfg.CurrentContext, f.Name(), nil
+}
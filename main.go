@@ -1,73 +1,156 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
-	"path"
+	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
-	"golang.org/x/oauth2/google"
 	"golang.org/x/sync/errgroup"
-	"google.golang.org/api/cloudresourcemanager/v1beta1"
-	"google.golang.org/api/container/v1"
+)
+
+var (
+	parallelism          = flag.Int("parallelism", 4, "number of clusters to process concurrently")
+	noPrefix             = flag.Bool("no-prefix", false, "don't prefix kubectl output with [project/location/cluster]")
+	failFast             = flag.Bool("fail-fast", false, "abort remaining clusters as soon as one fails (default is to continue and report every cluster)")
+	output               = flag.String("output", "support.zip", "bundle mode: path of the zip archive to write")
+	collectorsFile       = flag.String("collectors", "", "bundle mode: path to a YAML file of additional name -> argv collectors")
+	collectorParallelism = flag.Int("collector-parallelism", 4, "bundle mode: number of collectors to run concurrently per cluster")
 )
 
 func main() {
-	ctx := context.Background()
-	flag.CommandLine.Parse([]string{"-logtostderr"})
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "org-kubectl SCOPE[,SCOPE...] [kubectl args]")
+		fmt.Fprintln(os.Stderr, "org-kubectl bundle SCOPE[,SCOPE...]")
+		fmt.Fprintf(os.Stderr, "SCOPE is a provider URI: %v\n", strings.Join(exampleScopes(), ", "))
+		fmt.Fprintln(os.Stderr, "multiple comma separated SCOPEs (even across providers) are merged into one run")
+	}
 
-	if len(os.Args) <= 1 {
-		fmt.Fprintln(os.Stderr, "org-kubectl FOLDER [kubectl args]")
-		os.Exit(1)
+	cliArgs := os.Args[1:]
+	bundleMode := len(cliArgs) > 0 && cliArgs[0] == "bundle"
+	if bundleMode {
+		cliArgs = cliArgs[1:]
+	}
+	flag.CommandLine.Parse(cliArgs)
+	if lt := flag.CommandLine.Lookup("logtostderr"); lt != nil && lt.Value.String() == "false" {
+		flag.Set("logtostderr", "true")
 	}
 
-	crm, gke, err := clients(ctx)
-	if err != nil {
-		glog.Errorf("could not authenticate to google: %v", err)
+	args := flag.Args()
+	if len(args) < 1 {
+		flag.Usage()
 		os.Exit(1)
 	}
+	scope := args[0]
+	kubectlArgs := args[1:]
+
+	ctx := context.Background()
 
-	cachePath := path.Join(os.Getenv("HOME"), ".kube", "cache", "org-kubectl.json")
-	ancestorCache, _ := openCache(cachePath)
-	projects, err := findChildProjects(ctx, crm, os.Args[1], ancestorCache)
+	clusters, err := resolveClusters(ctx, scope)
 	if err != nil {
-		glog.Errorf("could not find projects: %v", err)
+		glog.Errorf("could not list clusters for %v: %v", scope, err)
 		os.Exit(1)
 	}
-	saveCache(cachePath, ancestorCache)
 
-	for _, p := range projects {
-		resp, err := gke.Projects.Zones.Clusters.List(p, "-").Context(ctx).Do()
+	if bundleMode {
+		collectors, err := loadCollectors(*collectorsFile)
 		if err != nil {
-			glog.Errorf("could not list gke clusters in %v: %v", p, err)
+			glog.Errorf("could not load collectors: %v", err)
 			os.Exit(1)
 		}
+		os.Exit(runBundle(ctx, clusters, *output, collectors))
+	}
 
-		for _, c := range resp.Clusters {
-			err := getClusterCredentials(ctx, p, c)
-			if err != nil {
-				glog.Errorf("could not get cluster credentials for %v in %v: %v", c.Name, p, err)
-				os.Exit(1)
+	os.Exit(runJobs(ctx, clusters, kubectlArgs))
+}
+
+func exampleScopes() []string {
+	examples := map[string]string{
+		"gcp":   "gcp://folders/123",
+		"aws":   "aws://ou-abcd",
+		"azure": "azure://mg-xyz",
+	}
+	scopes := make([]string, 0, len(registeredSchemes()))
+	for _, scheme := range registeredSchemes() {
+		if example, ok := examples[scheme]; ok {
+			scopes = append(scopes, example)
+		} else {
+			scopes = append(scopes, scheme+"://...")
+		}
+	}
+	return scopes
+}
+
+// runJobs runs kubectl for every cluster concurrently, bounded by
+// --parallelism, and returns the process exit code: 0 if every cluster
+// succeeded, 1 if any cluster failed. Results are reported for every
+// cluster regardless of failures unless --fail-fast is set.
+func runJobs(ctx context.Context, clusters []Cluster, kubectlArgs []string) int {
+	sem := make(chan struct{}, *parallelism)
+	grp, grpCtx := errgroup.WithContext(ctx)
+	var failed int32
+
+	for _, c := range clusters {
+		c := c
+		grp.Go(func() error {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			if *failFast && grpCtx.Err() != nil {
+				return nil
 			}
 
-			context := fmt.Sprintf("gke_%v_%v_%v", p, c.Zone, c.Name)
-			err = runKubectlCmd(ctx, context, os.Args[2:len(os.Args)])
+			cfg, err := c.provider.Kubeconfig(grpCtx, c)
 			if err != nil {
-				glog.Errorf("could not run kubectl for %v in %v: %v", c.Name, p, err)
-				os.Exit(1)
+				glog.Errorf("could not build kubeconfig for %v: %v", c.Label(), err)
+				atomic.AddInt32(&failed, 1)
+				if *failFast {
+					return err
+				}
+				return nil
 			}
-		}
+			contextName, kubeconfigPath, err := writeKubeconfigFile(cfg)
+			if err != nil {
+				glog.Errorf("could not write kubeconfig for %v: %v", c.Label(), err)
+				atomic.AddInt32(&failed, 1)
+				if *failFast {
+					return err
+				}
+				return nil
+			}
+			defer os.Remove(kubeconfigPath)
+
+			if err := runKubectlCmd(grpCtx, contextName, c.Label(), kubeconfigPath, kubectlArgs, !*noPrefix); err != nil {
+				glog.Errorf("could not run kubectl for %v: %v", c.Label(), err)
+				atomic.AddInt32(&failed, 1)
+				if *failFast {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	grp.Wait()
+
+	if failed > 0 {
+		return 1
 	}
+	return 0
 }
 
-func runKubectlCmd(ctx context.Context, context string, additionalArgs []string) error {
+var outputMu sync.Mutex
+
+func runKubectlCmd(ctx context.Context, context string, label string, kubeconfigPath string, additionalArgs []string, prefix bool) error {
 	args := append([]string{
 		"--context",
 		context,
@@ -75,117 +158,52 @@ func runKubectlCmd(ctx context.Context, context string, additionalArgs []string)
 	glog.Infof("kubectl %v", strings.Join(args, " "))
 
 	cmd := exec.CommandContext(ctx, "kubectl", args...)
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	err := cmd.Run()
-	return errors.Wrap(err, "could not run kubectl")
-}
-
-func getClusterCredentials(ctx context.Context, project string, cluster *container.Cluster) error {
-	cmd := exec.CommandContext(ctx,
-		"gcloud",
-		"--project",
-		project,
-		"container",
-		"clusters",
-		"get-credentials",
-		cluster.Name,
-		"--zone",
-		cluster.Zone,
-	)
-	err := cmd.Run()
-	return errors.Wrap(err, "could not get cluster credentials")
-}
-
-func clients(ctx context.Context) (*cloudresourcemanager.Service, *container.Service, error) {
-	httpClient, err := google.DefaultClient(ctx, cloudresourcemanager.CloudPlatformReadOnlyScope)
-	if err != nil {
-		return nil, nil, errors.Wrap(err, "could not authenticate to google")
-	}
+	cmd.Env = append(os.Environ(), "KUBECONFIG="+kubeconfigPath)
 
-	crm, err := cloudresourcemanager.New(httpClient)
+	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "could not create cloudresourcemanger client")
+		return errors.Wrap(err, "could not create stdout pipe")
 	}
-
-	gke, err := container.New(httpClient)
+	stderr, err := cmd.StderrPipe()
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "could not create gke client")
+		return errors.Wrap(err, "could not create stderr pipe")
 	}
-	return crm, gke, nil
-}
 
-func findChildProjects(ctx context.Context, crm *cloudresourcemanager.Service, parentResourceID string, ancestorCache map[string][]string) ([]string, error) {
-	projects, err := listProjects(ctx, crm)
-	if err != nil {
-		return nil, err
+	if !prefix {
+		label = ""
 	}
 
-	filteredProjects := []string{}
-	mu := &sync.Mutex{}
-
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-	grp, ctx := errgroup.WithContext(ctx)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go streamPrefixed(&wg, stdout, os.Stdout, label)
+	go streamPrefixed(&wg, stderr, os.Stderr, label)
 
-	glog.Infof("looking for projects with ancestors %v", parentResourceID)
-	for _, p := range projects {
-		p := p
-
-		mu.Lock()
-		ancestors, ok := ancestorCache[p]
-		mu.Unlock()
-		if !ok {
-			grp.Go(func() error {
-				resp, err := crm.Projects.GetAncestry(p, &cloudresourcemanager.GetAncestryRequest{}).Context(ctx).Do()
-				if err != nil {
-					return errors.Wrapf(err, "could not get ancestry for %v", p)
-				}
-				ancestors := []string{}
-				for _, ancestor := range resp.Ancestor {
-					ancestors = append(ancestors, ancestor.ResourceId.Id)
-					glog.Infof("ancestry for %v: %v", p, ancestor.ResourceId.Id)
-					if ancestor.ResourceId.Id == parentResourceID {
-						mu.Lock()
-						filteredProjects = append(filteredProjects, p)
-						mu.Unlock()
-					}
-				}
-				mu.Lock()
-				ancestorCache[p] = ancestors
-				mu.Unlock()
-				return nil
-			})
-		} else {
-			for _, ancestor := range ancestors {
-				if ancestor == parentResourceID {
-					mu.Lock()
-					filteredProjects = append(filteredProjects, p)
-					mu.Unlock()
-				}
-			}
-		}
+	if err := cmd.Start(); err != nil {
+		return errors.Wrap(err, "could not start kubectl")
 	}
+	wg.Wait()
 
-	if err := grp.Wait(); err != nil {
-		return nil, errors.Wrap(err, "could not get project ancestors")
-	}
-	return filteredProjects, nil
+	return errors.Wrap(cmd.Wait(), "could not run kubectl")
 }
 
-func listProjects(ctx context.Context, crm *cloudresourcemanager.Service) ([]string, error) {
-	projects := []string{}
-	err := crm.Projects.List().Context(ctx).Pages(
-		ctx, func(r *cloudresourcemanager.ListProjectsResponse) error {
-			for _, p := range r.Projects {
-				projects = append(projects, p.ProjectId)
-			}
-			return nil
-		})
-	if err != nil {
-		return nil, errors.Wrap(err, "could not list projects")
+// streamPrefixed copies r to w a line at a time, prefixing each line with
+// "[label] " when label is non-empty. Output is serialized across
+// concurrent callers one line at a time, so a line is never split by
+// output from another cluster; lines from different clusters still
+// interleave with each other.
+func streamPrefixed(wg *sync.WaitGroup, r io.Reader, w io.Writer, label string) {
+	defer wg.Done()
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		outputMu.Lock()
+		if label != "" {
+			fmt.Fprintf(w, "[%v] %v\n", label, scanner.Text())
+		} else {
+			fmt.Fprintln(w, scanner.Text())
+		}
+		outputMu.Unlock()
 	}
-	return projects, nil
 }
 
 func openCache(path string) (map[string][]string, error) {
@@ -202,6 +220,10 @@ func openCache(path string) (map[string][]string, error) {
 }
 
 func saveCache(path string, cache map[string][]string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.Wrap(err, "could not create cache directory")
+	}
+
 	f, err := os.Create(path)
 	if err != nil {
 		return errors.Wrap(err, "could not create cache file")
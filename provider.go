@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+// Cluster is a single cluster a Provider discovered, identified well
+// enough to label output and to hand back to the same Provider for a
+// kubeconfig.
+type Cluster struct {
+	Project  string
+	Location string
+	Name     string
+
+	// providerData carries whatever extra state the Provider that
+	// produced this Cluster needs to build a kubeconfig for it (a
+	// ClusterRef, an *eks.Cluster, ...). Only that Provider ever
+	// looks inside it.
+	providerData interface{}
+
+	// provider is the Provider that discovered this Cluster, and the one
+	// Kubeconfig must be built with. Clusters from different scopes can be
+	// fanned out over together (see resolveClusters), so each Cluster
+	// carries its own Provider rather than the caller having to track one.
+	provider Provider
+}
+
+// Label identifies a cluster in log lines and kubectl output prefixes. It
+// includes Location so two same-named clusters in different zones/regions
+// of the same project (or account) don't produce indistinguishable output.
+func (c Cluster) Label() string {
+	return fmt.Sprintf("%v/%v/%v", c.Project, c.Location, c.Name)
+}
+
+// Provider discovers clusters within some scope and builds kubeconfigs for
+// them. Each hyperscaler backend (gcp, aws, azure, ...) implements this
+// once; main just resolves the right Provider for the scope's scheme and
+// hands off, so org-kubectl <scope> [kubectl args] works the same way
+// regardless of which cloud <scope> names.
+type Provider interface {
+	ListClusters(ctx context.Context, scope string) ([]Cluster, error)
+	Kubeconfig(ctx context.Context, c Cluster) (*api.Config, error)
+}
+
+var providers = map[string]func() (Provider, error){}
+
+// registerProvider adds a Provider constructor to the registry under
+// scheme. Backends call this from an init() in their own file.
+func registerProvider(scheme string, newProvider func() (Provider, error)) {
+	providers[scheme] = newProvider
+}
+
+// providerFor resolves the Provider registered for scope's scheme (e.g.
+// "gcp" for "gcp://folders/123") and returns it along with the
+// scheme-specific remainder of scope to pass to ListClusters.
+func providerFor(scope string) (Provider, string, error) {
+	u, err := url.Parse(scope)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "could not parse scope %q", scope)
+	}
+
+	newProvider, ok := providers[u.Scheme]
+	if !ok {
+		return nil, "", errors.Errorf("no provider registered for scheme %q (have: %v)", u.Scheme, strings.Join(registeredSchemes(), ", "))
+	}
+
+	p, err := newProvider()
+	if err != nil {
+		return nil, "", err
+	}
+	return p, strings.TrimPrefix(scope, u.Scheme+"://"), nil
+}
+
+// resolveClusters resolves and lists clusters for one or more comma
+// separated scopes, which may name different providers (e.g.
+// "gcp://folders/123,gcp://fleet/hub-project" or even
+// "gcp://folders/123,aws://ou-abcd"), and returns their union with each
+// Cluster bound to the Provider that discovered it.
+func resolveClusters(ctx context.Context, scopes string) ([]Cluster, error) {
+	var clusters []Cluster
+	for _, scope := range strings.Split(scopes, ",") {
+		provider, providerScope, err := providerFor(scope)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not resolve provider for %v", scope)
+		}
+
+		found, err := provider.ListClusters(ctx, providerScope)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not list clusters for %v", scope)
+		}
+		for i := range found {
+			found[i].provider = provider
+		}
+		clusters = append(clusters, found...)
+	}
+	return clusters, nil
+}
+
+func registeredSchemes() []string {
+	schemes := make([]string, 0, len(providers))
+	for s := range providers {
+		schemes = append(schemes, s)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
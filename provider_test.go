@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/tools/clientcmd/api"
+)
+
+// stubProvider is a minimal Provider for exercising providerFor and
+// resolveClusters without touching any cloud API.
+type stubProvider struct {
+	clusters []Cluster
+}
+
+func (s *stubProvider) ListClusters(ctx context.Context, scope string) ([]Cluster, error) {
+	return s.clusters, nil
+}
+
+func (s *stubProvider) Kubeconfig(ctx context.Context, c Cluster) (*api.Config, error) {
+	return nil, errors.New("stubProvider.Kubeconfig not implemented")
+}
+
+func TestProviderFor(t *testing.T) {
+	registerProvider("providertest", func() (Provider, error) { return &stubProvider{}, nil })
+
+	cases := []struct {
+		name      string
+		scope     string
+		wantScope string
+		wantErr   bool
+	}{
+		{name: "known scheme", scope: "providertest://folders/123", wantScope: "folders/123"},
+		{name: "unknown scheme", scope: "nosuchscheme://x", wantErr: true},
+		{name: "unparseable scope", scope: "://bad", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, gotScope, err := providerFor(tc.scope)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("providerFor(%q): expected error, got nil", tc.scope)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("providerFor(%q): unexpected error: %v", tc.scope, err)
+			}
+			if p == nil {
+				t.Fatalf("providerFor(%q): got nil provider", tc.scope)
+			}
+			if gotScope != tc.wantScope {
+				t.Errorf("providerFor(%q): got scope %q, want %q", tc.scope, gotScope, tc.wantScope)
+			}
+		})
+	}
+}
+
+func TestResolveClustersMergesMultipleScopes(t *testing.T) {
+	registerProvider("resolvetest-a", func() (Provider, error) {
+		return &stubProvider{clusters: []Cluster{{Project: "proj-a", Name: "one"}}}, nil
+	})
+	registerProvider("resolvetest-b", func() (Provider, error) {
+		return &stubProvider{clusters: []Cluster{{Project: "proj-b", Name: "two"}}}, nil
+	})
+
+	clusters, err := resolveClusters(context.Background(), "resolvetest-a://x,resolvetest-b://y")
+	if err != nil {
+		t.Fatalf("resolveClusters: unexpected error: %v", err)
+	}
+	if len(clusters) != 2 {
+		t.Fatalf("resolveClusters: got %d clusters, want 2", len(clusters))
+	}
+	if clusters[0].Project != "proj-a" || clusters[1].Project != "proj-b" {
+		t.Errorf("resolveClusters: got %+v, want one cluster from each scope in order", clusters)
+	}
+	if clusters[0].provider == nil || clusters[1].provider == nil {
+		t.Errorf("resolveClusters: every cluster must carry the provider that discovered it")
+	}
+}
+
+func TestResolveClustersPropagatesError(t *testing.T) {
+	if _, err := resolveClusters(context.Background(), "nosuchscheme://x"); err == nil {
+		t.Fatal("resolveClusters: expected error for unknown scheme, got nil")
+	}
+}